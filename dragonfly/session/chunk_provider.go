@@ -0,0 +1,64 @@
+package session
+
+import (
+	"context"
+
+	"github.com/dragonfly-tech/dragonfly/dragonfly/world"
+)
+
+// ChunkProvider supplies the chunks around a viewer to a Session, in place of the session driving a
+// world.Loader directly. Alternative implementations can be plugged in through WithChunkProvider, such as a
+// provider that logs visited chunks to disk, a read-only provider backed by a pre-rendered region file, or
+// a test provider serving canned chunks, without needing to fork the session layer.
+type ChunkProvider interface {
+	// Init binds the provider to the viewer it should supply chunks to. It is called once by Session, after
+	// any options passed to New have been applied and before the first call to Tick.
+	Init(viewer world.Viewer) error
+	// Tick is called regularly (at a fixed 20Hz, the same rate the session previously hard-coded) so the
+	// provider can load and send any chunks the viewer does not yet have. ctx is cancelled once the session
+	// is closing.
+	Tick(ctx context.Context) error
+	// SetRadius changes the radius, in chunks, that the provider loads around the viewer.
+	SetRadius(radius int)
+	// Close releases any resources held by the provider.
+	Close() error
+}
+
+// loaderChunkProvider is the default ChunkProvider used by a Session that is not given one explicitly
+// through WithChunkProvider. It preserves the session's original behaviour of loading chunks straight from
+// a world.World through a world.Loader.
+type loaderChunkProvider struct {
+	world  *world.World
+	radius int
+
+	loader *world.Loader
+}
+
+// NewLoaderChunkProvider returns the default ChunkProvider, which loads chunks for its viewer directly from
+// w using a world.Loader of the given radius.
+func NewLoaderChunkProvider(w *world.World, radius int) ChunkProvider {
+	return &loaderChunkProvider{world: w, radius: radius}
+}
+
+// Init creates the underlying world.Loader for viewer.
+func (p *loaderChunkProvider) Init(viewer world.Viewer) error {
+	p.loader = world.NewLoader(p.radius, p.world, viewer)
+	return nil
+}
+
+// Tick loads the next batch of chunks around the viewer, the same way Session.sendChunks did before
+// ChunkProvider existed.
+func (p *loaderChunkProvider) Tick(ctx context.Context) error {
+	return p.loader.Load(4)
+}
+
+// SetRadius changes the radius of the underlying world.Loader.
+func (p *loaderChunkProvider) SetRadius(radius int) {
+	p.radius = radius
+	p.loader.ChangeRadius(radius)
+}
+
+// Close closes the underlying world.Loader.
+func (p *loaderChunkProvider) Close() error {
+	return p.loader.Close()
+}
@@ -0,0 +1,49 @@
+package session
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/sandertv/gophertunnel/minecraft/protocol/packet"
+)
+
+// Latency returns the round-trip time of the last NetworkStackLatency ping answered by the session's
+// client. It is safe to call from any goroutine, such as one rendering it on a scoreboard.
+func (s *Session) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.latency))
+}
+
+// superviseConnection pings the client every s.pingPeriod with a NetworkStackLatency packet and closes the
+// session if no response arrives within s.idleTimeout, catching half-open clients that a blocking
+// conn.ReadPacket would otherwise never notice. It returns once the session starts closing.
+func (s *Session) superviseConnection() {
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(&s.lastPong, now)
+
+	t := time.NewTicker(s.pingPeriod)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if time.Since(time.Unix(0, atomic.LoadInt64(&s.lastPong))) > s.idleTimeout {
+				s.log.Errorf("closing session for %v: idle timeout of %v exceeded", s.conn.IdentityData().DisplayName, s.idleTimeout)
+				s.RequestClose()
+				return
+			}
+			sent := time.Now().UnixNano()
+			atomic.StoreInt64(&s.lastPing, sent)
+			s.writePacket(&packet.NetworkStackLatency{Timestamp: sent, NeedsResponse: true})
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// handleNetworkStackLatency handles a NetworkStackLatency packet sent back by the client in response to a
+// ping from superviseConnection, recording the round-trip time and marking the connection as alive.
+func (s *Session) handleNetworkStackLatency(pk *packet.NetworkStackLatency) {
+	atomic.StoreInt64(&s.lastPong, time.Now().UnixNano())
+	if sent := atomic.LoadInt64(&s.lastPing); pk.Timestamp == sent {
+		atomic.StoreInt64(&s.latency, time.Since(time.Unix(0, sent)).Nanoseconds())
+	}
+}
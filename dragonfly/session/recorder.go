@@ -0,0 +1,123 @@
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"github.com/sandertv/gophertunnel/minecraft/protocol/packet"
+)
+
+// recordingMagic is written at the start of every file produced by a Recorder. Replay rejects any file that
+// does not start with it, so that garbage or unrelated files fail fast instead of producing confusing
+// decode errors further in.
+const recordingMagic uint32 = 0x594c5052 // "RPLY" read as a little endian uint32.
+
+// recordingVersion is the protocol version of the packets framed in a recording. Replay refuses to play
+// back a recording whose version does not match the one it was built with, since packet encodings are not
+// guaranteed to be compatible across protocol versions.
+const recordingVersion int32 = protocol.CurrentProtocol
+
+// Direction indicates whether a recorded packet was sent to the client (Outgoing) or received from it
+// (Incoming).
+type Direction uint32
+
+// Directions a recorded packet may have travelled in.
+const (
+	Incoming Direction = iota
+	Outgoing
+)
+
+// Recorder records every packet a Session sends and receives to a writer, framed with the wall-clock delta
+// since recording started and the direction the packet travelled in. The resulting file can be fed to
+// Replay to reproduce the same sequence of packets against a *world.World.
+//
+// A Recorder does not buffer: every packet is encoded and written to w as soon as it passes through the
+// session, so a crash part way through a session still leaves a usable recording.
+type Recorder struct {
+	w     io.Writer
+	start time.Time
+	pool  packet.Pool
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewRecorder creates a Recorder that writes a header followed by a framed entry for every packet s writes
+// or handles to w. The Recorder hooks into s's outgoing and incoming middleware for the remaining lifetime
+// of the session; it cannot be detached.
+func NewRecorder(s *Session, w io.Writer) (*Recorder, error) {
+	r := &Recorder{w: w, start: time.Now(), pool: packet.NewPool()}
+	if err := r.writeHeader(s); err != nil {
+		return nil, fmt.Errorf("session: write recording header: %w", err)
+	}
+	s.addOutgoingMiddleware(func(pk packet.Packet) { r.record(Outgoing, pk) })
+	s.addIncomingMiddleware(func(pk packet.Packet) { r.record(Incoming, pk) })
+	return r, nil
+}
+
+// writeHeader writes the magic, protocol version and display name of the session's controllable, so that
+// Replay can recreate a Controllable with the same name. No other identity data, such as the UUID, is
+// written.
+func (r *Recorder) writeHeader(s *Session) error {
+	identity := s.conn.IdentityData()
+	buf := bytes.NewBuffer(nil)
+	if err := binary.Write(buf, binary.LittleEndian, recordingMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, recordingVersion); err != nil {
+		return err
+	}
+	name := []byte(identity.DisplayName)
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(name))); err != nil {
+		return err
+	}
+	buf.Write(name)
+	_, err := r.w.Write(buf.Bytes())
+	return err
+}
+
+// directionBit is set in the top bit of an entry's direction+id field when the packet it frames is
+// Outgoing. Packet IDs never use the top bit, so the two can share a single uint32.
+const directionBit uint32 = 1 << 31
+
+// record looks pk up in the Recorder's packet pool, to make sure it is one Replay will be able to
+// reconstruct, then writes it to the underlying writer framed as {uint64 nanos since start, uint32
+// direction+id, varuint length, payload}, matching the format Replay decodes. Errors are sticky: once
+// record has failed, the error is kept in r.err and record becomes a no-op for the remainder of the
+// session. The error is not logged.
+func (r *Recorder) record(dir Direction, pk packet.Packet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if _, ok := r.pool[pk.ID()]; !ok {
+		r.err = fmt.Errorf("session: packet %T (id %v) is not in the recorder's packet pool", pk, pk.ID())
+		return
+	}
+
+	payload := bytes.NewBuffer(nil)
+	pk.Marshal(protocol.NewWriter(payload, 0))
+
+	directionID := pk.ID()
+	if dir == Outgoing {
+		directionID |= directionBit
+	}
+
+	header := binary.LittleEndian.AppendUint64(nil, uint64(time.Since(r.start)))
+	header = binary.LittleEndian.AppendUint32(header, directionID)
+	header = binary.AppendUvarint(header, uint64(payload.Len()))
+
+	if _, err := r.w.Write(header); err != nil {
+		r.err = fmt.Errorf("session: write recording entry header: %w", err)
+		return
+	}
+	if _, err := r.w.Write(payload.Bytes()); err != nil {
+		r.err = fmt.Errorf("session: write recording entry payload: %w", err)
+	}
+}
@@ -0,0 +1,128 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dragonfly-tech/dragonfly/dragonfly/world"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"github.com/sandertv/gophertunnel/minecraft/protocol/packet"
+	"github.com/sirupsen/logrus"
+)
+
+// Replay reads a recording produced by a Recorder from r and re-emits every packet it contains into a new
+// Session attached to w, at the same wall-clock deltas they were originally recorded at. Incoming packets
+// are fed back through the Session's handlers exactly as if the original client had sent them; outgoing
+// packets are discarded, since the replay Session has no real connection to send them to.
+//
+// Replay blocks until every entry in the recording has been replayed or an error occurs.
+func Replay(r io.Reader, w *world.World, log *logrus.Logger) error {
+	br := bufio.NewReader(r)
+	magic, version, name, err := readHeader(br)
+	if err != nil {
+		return fmt.Errorf("session: read recording header: %w", err)
+	}
+	if magic != recordingMagic {
+		return fmt.Errorf("session: not a session recording")
+	}
+	if version != recordingVersion {
+		return fmt.Errorf("session: recording was made with protocol %v, replayer expects %v", version, recordingVersion)
+	}
+
+	c := &replayControllable{name: name}
+	// The replay session has no real connection behind it, so conn is left nil; handlePacket and its
+	// handlers must not assume s.conn is always set.
+	s := New(c, nil, w, 0, log)
+	pool := packet.NewPool()
+
+	// Tick the session's chunk provider for as long as the replay runs, the same way a live session does.
+	closeChan := make(chan struct{})
+	go s.sendChunks(closeChan)
+	defer func() { closeChan <- struct{}{} }()
+
+	start := time.Now()
+	for {
+		nanos, dir, id, payload, err := readEntry(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("session: read recording entry: %w", err)
+		}
+		if dir != Incoming {
+			// Outgoing packets were sent to the original client; the replayer has nothing to send them to.
+			continue
+		}
+
+		factory, ok := pool[id]
+		if !ok {
+			return fmt.Errorf("session: unknown packet ID %v in recording", id)
+		}
+		pk := factory()
+		pk.Unmarshal(protocol.NewReader(bytes.NewReader(payload), 0))
+
+		if d := time.Duration(nanos) - time.Since(start); d > 0 {
+			time.Sleep(d)
+		}
+		if err := s.handlePacket(pk); err != nil {
+			return fmt.Errorf("session: replay packet %T: %w", pk, err)
+		}
+	}
+}
+
+// readHeader reads and parses the header written by Recorder.writeHeader: a fixed-size magic and protocol
+// version, followed by a length-prefixed display name.
+func readHeader(r io.Reader) (magic uint32, version int32, name string, err error) {
+	if err = binary.Read(r, binary.LittleEndian, &magic); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return
+	}
+	var nameLen uint32
+	if err = binary.Read(r, binary.LittleEndian, &nameLen); err != nil {
+		return
+	}
+	buf := make([]byte, nameLen)
+	_, err = io.ReadFull(r, buf)
+	name = string(buf)
+	return
+}
+
+// readEntry reads a single framed entry written by Recorder.record: {uint64 nanos, uint32 direction+id,
+// varuint length, payload}. r must be a *bufio.Reader, since the varuint length needs an io.ByteReader.
+func readEntry(r *bufio.Reader) (nanos uint64, dir Direction, id uint32, payload []byte, err error) {
+	if err = binary.Read(r, binary.LittleEndian, &nanos); err != nil {
+		return
+	}
+	var directionID uint32
+	if err = binary.Read(r, binary.LittleEndian, &directionID); err != nil {
+		return
+	}
+	if directionID&directionBit != 0 {
+		dir = Outgoing
+	}
+	id = directionID &^ directionBit
+
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	return
+}
+
+// replayControllable is a minimal Controllable used by Replay to drive a world.Loader without a real
+// client attached. It discards everything sent to it, since there is nobody on the other end to see it.
+type replayControllable struct {
+	world.NopViewer
+	name string
+}
+
+func (r *replayControllable) Name() string { return r.name }
+func (r *replayControllable) Close() error { return nil }
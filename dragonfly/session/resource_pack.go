@@ -0,0 +1,157 @@
+package session
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"github.com/sandertv/gophertunnel/minecraft/protocol/packet"
+)
+
+// packChunkSize is the maximum number of bytes of pack content sent in a single ResourcePackChunkData
+// packet. The Bedrock protocol requires pack content to be sliced into chunks no larger than 1 MiB.
+const packChunkSize = 1024 * 1024
+
+// ResourcePack represents a single resource pack that a ResourcePackManager can serve to clients.
+type ResourcePack struct {
+	// UUID is the unique identifier of the resource pack, as it will be advertised to the client.
+	UUID string
+	// Version is the version of the resource pack, in the usual '1.0.0' format.
+	Version string
+	// Content holds the raw bytes of the compressed resource pack archive.
+	Content []byte
+	// EncryptionKey is the key used to encrypt Content, if any. It is left empty for packs that are not
+	// encrypted.
+	EncryptionKey string
+}
+
+// hash returns the SHA256 hash of the pack's content, as required by the ResourcePackDataInfo packet.
+func (pack ResourcePack) hash() []byte {
+	sum := sha256.Sum256(pack.Content)
+	return sum[:]
+}
+
+// chunkCount returns the number of chunks the pack's content is split into when sent to a client.
+func (pack ResourcePack) chunkCount() uint32 {
+	return uint32((len(pack.Content) + packChunkSize - 1) / packChunkSize)
+}
+
+// chunk returns the i-th chunk of the pack's content, sized at most packChunkSize bytes.
+func (pack ResourcePack) chunk(i uint32) []byte {
+	start := int(i) * packChunkSize
+	end := start + packChunkSize
+	if end > len(pack.Content) {
+		end = len(pack.Content)
+	}
+	return pack.Content[start:end]
+}
+
+// ResourcePackManager holds the resource packs a session should serve to its client, and drives the
+// ResourcePacksInfo/ResourcePackStack/ResourcePackClientResponse/ResourcePackChunkRequest handshake that the
+// Bedrock protocol requires before a client may join the world. The packs it serves are fixed at
+// construction, so a ResourcePackManager may be shared between sessions and read concurrently without
+// locking.
+type ResourcePackManager struct {
+	// order holds the packs in the order they were passed to NewResourcePackManager, since the order of the
+	// ResourcePackStack determines texture-override priority and must stay stable across joins.
+	order []ResourcePack
+	packs map[string]ResourcePack
+}
+
+// NewResourcePackManager returns a ResourcePackManager that will serve the packs passed to clients, in the
+// order given.
+func NewResourcePackManager(packs ...ResourcePack) *ResourcePackManager {
+	m := &ResourcePackManager{order: packs, packs: make(map[string]ResourcePack, len(packs))}
+	for _, pack := range packs {
+		m.packs[pack.UUID] = pack
+	}
+	return m
+}
+
+// start sends the ResourcePacksInfo packet that kicks off the handshake. The session does not proceed to
+// completeJoin until the client has responded with PackResponseCompleted.
+func (m *ResourcePackManager) start(s *Session) {
+	info := &packet.ResourcePacksInfo{}
+	for _, pack := range m.order {
+		texturePack := protocol.TexturePackInfo{
+			UUID:            pack.UUID,
+			Version:         pack.Version,
+			Size:            uint64(len(pack.Content)),
+			ContentKey:      pack.EncryptionKey,
+			ContentIdentity: pack.UUID,
+		}
+		info.TexturePacks = append(info.TexturePacks, texturePack)
+	}
+	s.writePacket(info)
+}
+
+// handleClientResponse handles a ResourcePackClientResponse sent by the client, replying with pack data
+// info for every pack it needs to download, sending the ResourcePackStack once it reports it has every
+// pack, and completing the join once it reports PackResponseCompleted.
+func (m *ResourcePackManager) handleClientResponse(s *Session, pk *packet.ResourcePackClientResponse) error {
+	if m == nil {
+		// The session was not configured with resource packs: there is nothing to respond to.
+		return nil
+	}
+	switch pk.Response {
+	case packet.PackResponseRefused:
+		// A client that refuses to download the packs cannot continue; closing the connection matches the
+		// way other fatal protocol violations are handled elsewhere in the session.
+		return fmt.Errorf("client refused to download resource packs")
+	case packet.PackResponseSendPacks:
+		for _, uuid := range pk.PacksToDownload {
+			pack, ok := m.packs[stripVersion(uuid)]
+			if !ok {
+				return fmt.Errorf("client requested unknown resource pack %v", uuid)
+			}
+			s.writePacket(&packet.ResourcePackDataInfo{
+				UUID:          pack.UUID,
+				DataChunkSize: packChunkSize,
+				ChunkCount:    pack.chunkCount(),
+				Size:          uint64(len(pack.Content)),
+				Hash:          pack.hash(),
+			})
+		}
+	case packet.PackResponseHaveAllPacks:
+		stack := &packet.ResourcePackStack{}
+		for _, pack := range m.order {
+			stack.TexturePacks = append(stack.TexturePacks, protocol.StackPack{UUID: pack.UUID, Version: pack.Version})
+		}
+		s.writePacket(stack)
+	case packet.PackResponseCompleted:
+		s.completeJoin()
+	}
+	return nil
+}
+
+// handleChunkRequest handles a ResourcePackChunkRequest, replying with the requested chunk of pack content.
+func (m *ResourcePackManager) handleChunkRequest(s *Session, pk *packet.ResourcePackChunkRequest) error {
+	if m == nil {
+		return nil
+	}
+	pack, ok := m.packs[stripVersion(pk.UUID)]
+	if !ok {
+		return fmt.Errorf("chunk request for unknown resource pack %v", pk.UUID)
+	}
+	if pk.ChunkIndex >= pack.chunkCount() {
+		return fmt.Errorf("chunk request out of range for resource pack %v", pk.UUID)
+	}
+	s.writePacket(&packet.ResourcePackChunkData{
+		UUID:       pack.UUID,
+		ChunkIndex: pk.ChunkIndex,
+		DataOffset: uint64(pk.ChunkIndex) * packChunkSize,
+		Data:       pack.chunk(pk.ChunkIndex),
+	})
+	return nil
+}
+
+// stripVersion strips the '_version' suffix that the client appends to a pack UUID when requesting it, so
+// that the UUID can be looked up directly in the manager's pack map.
+func stripVersion(uuid string) string {
+	for i := len(uuid) - 1; i >= 0; i-- {
+		if uuid[i] == '_' {
+			return uuid[:i]
+		}
+	}
+	return uuid
+}
@@ -2,6 +2,7 @@ package session
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/dragonfly-tech/dragonfly/dragonfly/player/chat"
 	"github.com/dragonfly-tech/dragonfly/dragonfly/world"
@@ -27,10 +28,12 @@ type Session struct {
 	cmdOrigin     protocol.CommandOrigin
 	scoreboardObj atomic.Value
 
-	chunkBuf       *bytes.Buffer
-	chunkLoader    atomic.Value
-	chunkRadius    int32
-	maxChunkRadius int32
+	chunkBuf      *bytes.Buffer
+	chunkProvider ChunkProvider
+
+	// resourcePacks holds the resource packs the session must negotiate with the client before it is
+	// allowed to join the world. It is nil if the session was created without WithResourcePacks.
+	resourcePacks *ResourcePackManager
 
 	// currentEntityRuntimeID holds the runtime ID assigned to the last entity. It is incremented for every
 	// entity spawned to the session.
@@ -42,6 +45,37 @@ type Session struct {
 	// onStop is called when the session is stopped. The controllable passed is the controllable that the
 	// session controls.
 	onStop func(controllable Controllable)
+
+	middlewareMu sync.Mutex
+	// outgoingMiddleware is called, in order, for every packet written through writePacket, before it is
+	// sent to the connection. It allows other parts of the session package, such as the Recorder, to
+	// observe outgoing traffic without forking writePacket itself.
+	outgoingMiddleware []func(pk packet.Packet)
+	// incomingMiddleware is called, in order, for every packet handled through handlePacket, before it is
+	// dispatched to its handler.
+	incomingMiddleware []func(pk packet.Packet)
+
+	// pingPeriod is the interval at which the connection supervisor pings the client to measure latency and
+	// detect a half-open connection. idleTimeout is how long the supervisor waits for a response before
+	// giving up on the client and closing the session.
+	pingPeriod  time.Duration
+	idleTimeout time.Duration
+	// latency holds the last measured round-trip time to the client, in nanoseconds, so that Latency can be
+	// read without locking.
+	latency int64
+	// lastPing and lastPong hold the UnixNano of the last ping sent and response received by the connection
+	// supervisor, respectively.
+	lastPing int64
+	lastPong int64
+	// closing is closed when the session starts shutting down, signalling the connection supervisor (and
+	// any other session-lifetime goroutines) to stop. closeOnce guards it, so that Close remains safe to
+	// call more than once.
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// joinOnce guards completeJoin, so that a duplicate or retransmitted PackResponseCompleted cannot add
+	// the controllable to the world or player list more than once.
+	joinOnce sync.Once
 }
 
 // Nop represents a no-operation session. It does not do anything when sending a packet to it.
@@ -55,53 +89,113 @@ var sessionMutex sync.Mutex
 // selfEntityRuntimeID is the entity runtime (or unique) ID of the controllable that the session holds.
 const selfEntityRuntimeID = 1
 
+// Option configures a Session returned by New. Options are applied in the order they are passed, after the
+// session's other fields have been initialised.
+type Option func(s *Session)
+
+// WithResourcePacks returns an Option that makes the session negotiate and deliver the packs held by m
+// before the controllable is added to the world. Without this option, no resource pack handshake is
+// performed and the session joins the world immediately, as before.
+func WithResourcePacks(m *ResourcePackManager) Option {
+	return func(s *Session) {
+		s.resourcePacks = m
+	}
+}
+
+// WithChunkProvider returns an Option that makes the session use p to supply chunks to its controllable,
+// instead of the default world.Loader-backed provider. This allows world-ripper/archiver tooling, read-only
+// providers backed by a pre-rendered region file, or test providers serving canned chunks to be built on
+// top of the session layer without forking it.
+func WithChunkProvider(p ChunkProvider) Option {
+	return func(s *Session) {
+		s.chunkProvider = p
+	}
+}
+
+// WithKeepAlive returns an Option that overrides the default ping period and idle timeout the session's
+// connection supervisor uses: a session pings the client every period and closes it if no response arrives
+// within timeout. The defaults, a 1 second period and a 30 second timeout, mirror the way multiple pings
+// per idle period are used elsewhere to tolerate the occasional lost packet without dropping the client.
+func WithKeepAlive(period, timeout time.Duration) Option {
+	return func(s *Session) {
+		s.pingPeriod = period
+		s.idleTimeout = timeout
+	}
+}
+
 // New returns a new session using a controllable entity. The session will control this entity using the
 // packets that it receives.
 // New takes the connection from which to accept packets. It will start handling these packets after a call to
 // Session.Start().
-func New(c Controllable, conn *minecraft.Conn, w *world.World, maxChunkRadius int, log *logrus.Logger) *Session {
+func New(c Controllable, conn *minecraft.Conn, w *world.World, maxChunkRadius int, log *logrus.Logger, opts ...Option) *Session {
 	s := &Session{
-		c:              c,
-		conn:           conn,
-		log:            log,
-		chunkBuf:       bytes.NewBuffer(make([]byte, 0, 4096)),
-		world:          w,
-		chunkRadius:    int32(maxChunkRadius / 2),
-		maxChunkRadius: int32(maxChunkRadius),
+		c:        c,
+		conn:     conn,
+		log:      log,
+		chunkBuf: bytes.NewBuffer(make([]byte, 0, 4096)),
+		world:    w,
 		entityRuntimeIDs: map[world.Entity]uint64{
 			// We initialise the runtime ID of the controllable of the session. It will always have runtime ID
 			// 1, because we treat entity runtime IDs as session-local.
 			c: selfEntityRuntimeID,
 		},
 		currentEntityRuntimeID: 1,
+		pingPeriod:             time.Second,
+		idleTimeout:            time.Second * 30,
+		closing:                make(chan struct{}),
 	}
-	s.chunkLoader.Store(world.NewLoader(maxChunkRadius/2, w, s))
 	s.scoreboardObj.Store("")
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.chunkProvider == nil {
+		s.chunkProvider = NewLoaderChunkProvider(w, maxChunkRadius/2)
+	}
+	if err := s.chunkProvider.Init(s); err != nil {
+		log.Errorf("error initialising chunk provider: %v", err)
+	}
 	return s
 }
 
-// Start makes the session start handling incoming packets from the client and initialises the controllable of
-// the session in the world.
+// Start makes the session start handling incoming packets from the client. If the session was configured
+// with WithResourcePacks, the controllable is only added to the world once the resource pack handshake with
+// the client has completed; otherwise it joins immediately.
 // The function passed will be called when the session stops running.
 func (s *Session) Start(onStop func(controllable Controllable)) {
 	s.onStop = onStop
-	s.initPlayerList()
+	go s.handlePackets()
+	go s.superviseConnection()
 
-	s.world.AddEntity(s.c)
-	s.SendAvailableCommands()
+	if s.resourcePacks != nil {
+		s.resourcePacks.start(s)
+		return
+	}
+	s.completeJoin()
+}
 
-	go s.handlePackets()
+// completeJoin adds the controllable of the session to the world and sends it the information it needs to
+// play, such as the player list and the available commands. It is called directly by Start if no resource
+// pack handshake is configured, or after that handshake completes otherwise. completeJoin only ever runs
+// once for a session, so a duplicate or retransmitted PackResponseCompleted cannot join it twice.
+func (s *Session) completeJoin() {
+	s.joinOnce.Do(func() {
+		s.initPlayerList()
 
-	yellow := text.Yellow()
-	chat.Global.Println(yellow(s.conn.IdentityData().DisplayName, "has joined the game"))
+		s.world.AddEntity(s.c)
+		s.SendAvailableCommands()
+
+		yellow := text.Yellow()
+		chat.Global.Println(yellow(s.conn.IdentityData().DisplayName, "has joined the game"))
+	})
 }
 
 // Close closes the session, which in turn closes the controllable and the connection that the session
 // manages.
 func (s *Session) Close() error {
+	s.closeOnce.Do(func() { close(s.closing) })
 	_ = s.c.Close()
 	_ = s.conn.Close()
-	_ = s.chunkLoader.Load().(*world.Loader).Close()
+	_ = s.chunkProvider.Close()
 	s.world.RemoveEntity(s.c)
 
 	yellow := text.Yellow()
@@ -149,14 +243,17 @@ func (s *Session) handlePackets() {
 	}
 }
 
-// sendChunks continuously sends chunks to the player, until a value is sent to the closeChan passed.
+// sendChunks continuously ticks the session's chunk provider, until a value is sent to the closeChan
+// passed.
 func (s *Session) sendChunks(closeChan <-chan struct{}) {
 	t := time.NewTicker(time.Second / 20)
 	defer t.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	for {
 		select {
 		case <-t.C:
-			if err := s.chunkLoader.Load().(*world.Loader).Load(4); err != nil {
+			if err := s.chunkProvider.Tick(ctx); err != nil {
 				// The world was closed. We need to close the session as soon as possible.
 
 				s.log.Errorf("error loading chunk: %v", err)
@@ -168,9 +265,32 @@ func (s *Session) sendChunks(closeChan <-chan struct{}) {
 	}
 }
 
+// addOutgoingMiddleware registers f to be called with every packet written through writePacket, before it
+// is sent to the connection.
+func (s *Session) addOutgoingMiddleware(f func(pk packet.Packet)) {
+	s.middlewareMu.Lock()
+	s.outgoingMiddleware = append(s.outgoingMiddleware, f)
+	s.middlewareMu.Unlock()
+}
+
+// addIncomingMiddleware registers f to be called with every packet handled through handlePacket, before it
+// is dispatched to its handler.
+func (s *Session) addIncomingMiddleware(f func(pk packet.Packet)) {
+	s.middlewareMu.Lock()
+	s.incomingMiddleware = append(s.incomingMiddleware, f)
+	s.middlewareMu.Unlock()
+}
+
 // handlePacket handles an incoming packet, processing it accordingly. If the packet had invalid data or was
 // otherwise not valid in its context, an error is returned.
 func (s *Session) handlePacket(pk packet.Packet) error {
+	s.middlewareMu.Lock()
+	incoming := s.incomingMiddleware
+	s.middlewareMu.Unlock()
+	for _, f := range incoming {
+		f(pk)
+	}
+
 	switch pk := pk.(type) {
 	case *packet.Text:
 		return s.handleText(pk)
@@ -180,18 +300,44 @@ func (s *Session) handlePacket(pk packet.Packet) error {
 		return s.handleMovePlayer(pk)
 	case *packet.RequestChunkRadius:
 		return s.handleRequestChunkRadius(pk)
+	case *packet.ResourcePackClientResponse:
+		return s.resourcePacks.handleClientResponse(s, pk)
+	case *packet.ResourcePackChunkRequest:
+		return s.resourcePacks.handleChunkRequest(s, pk)
+	case *packet.NetworkStackLatency:
+		s.handleNetworkStackLatency(pk)
 	case *packet.BossEvent: // No need to do anything here. We don't care about these when they're incoming.
 	default:
-		s.log.Debugf("unhandled packet %T%v from %v\n", pk, fmt.Sprintf("%+v", pk)[1:], s.conn.RemoteAddr())
+		if s.conn != nil {
+			s.log.Debugf("unhandled packet %T%v from %v\n", pk, fmt.Sprintf("%+v", pk)[1:], s.conn.RemoteAddr())
+		} else {
+			// s.conn is nil for sessions driven by Replay, which have no real connection behind them.
+			s.log.Debugf("unhandled packet %T%v\n", pk, fmt.Sprintf("%+v", pk)[1:])
+		}
 	}
 	return nil
 }
 
-// writePacket writes a packet to the session's connection if it is not Nop.
+// handleRequestChunkRadius handles a RequestChunkRadius packet, resizing the view distance the session's
+// chunkProvider loads around its controllable and acknowledging the change to the client.
+func (s *Session) handleRequestChunkRadius(pk *packet.RequestChunkRadius) error {
+	s.chunkProvider.SetRadius(int(pk.ChunkRadius))
+	s.writePacket(&packet.ChunkRadiusUpdated{ChunkRadius: pk.ChunkRadius})
+	return nil
+}
+
+// writePacket writes a packet to the session's connection if it is not Nop. Sessions with no connection,
+// such as the one Replay drives, are treated the same way: there is nothing to write the packet to.
 func (s *Session) writePacket(pk packet.Packet) {
-	if s == Nop {
+	if s == Nop || s.conn == nil {
 		return
 	}
+	s.middlewareMu.Lock()
+	outgoing := s.outgoingMiddleware
+	s.middlewareMu.Unlock()
+	for _, f := range outgoing {
+		f(pk)
+	}
 	_ = s.conn.WritePacket(pk)
 }
 